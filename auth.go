@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned by AuthProvider.Authenticate when the
+// nickname/password pair doesn't check out, without revealing which half failed.
+var ErrInvalidCredentials = errors.New("invalid nickname or password")
+
+// AuthProvider validates a nickname/password pair before a client is let
+// past the handshake and allowed to join rooms.
+type AuthProvider interface {
+	Authenticate(nickname, password string) error
+}
+
+// bcryptFileAuthProvider validates credentials against a flat file of
+// "nickname:bcrypt-hash" lines, re-read on every call so rotating
+// credentials doesn't require a server restart.
+type bcryptFileAuthProvider struct {
+	path string
+}
+
+func newBcryptFileAuthProvider(path string) *bcryptFileAuthProvider {
+	return &bcryptFileAuthProvider{path: path}
+}
+
+func (p *bcryptFileAuthProvider) Authenticate(nickname, password string) error {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		nickname2, hash, ok := strings.Cut(line, ":")
+		if !ok || nickname2 != nickname {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return ErrInvalidCredentials
+		}
+		return nil
+	}
+	return ErrInvalidCredentials
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Broadcaster decouples the hub from how messages actually travel between
+// GoWebChat instances: a single process can keep everything in memory, or
+// many instances behind a load balancer can share a room over Redis.
+type Broadcaster interface {
+	// Publish sends msg to every subscriber, including ones in other processes.
+	Publish(msg []byte) error
+	// Subscribe blocks, delivering every published message to out until the
+	// underlying subscription ends.
+	Subscribe(out chan<- []byte)
+}
+
+// memoryBroadcaster is the fallback used when no Redis address is
+// configured; it keeps broadcasting local to this process so a single
+// GoWebChat instance behaves exactly as it did before Broadcaster existed.
+type memoryBroadcaster struct {
+	ch chan []byte
+}
+
+func newMemoryBroadcaster() *memoryBroadcaster {
+	return &memoryBroadcaster{ch: make(chan []byte, defaultSendBufferSize)}
+}
+
+func (b *memoryBroadcaster) Publish(msg []byte) error {
+	b.ch <- msg
+	return nil
+}
+
+func (b *memoryBroadcaster) Subscribe(out chan<- []byte) {
+	for msg := range b.ch {
+		out <- msg
+	}
+}
+
+// redisBroadcaster lets multiple GoWebChat instances share a single chat
+// room by publishing inbound messages to, and fanning out messages
+// received from, a shared Redis pub/sub channel.
+type redisBroadcaster struct {
+	client  *redis.Client
+	channel string
+	ctx     context.Context
+}
+
+func newRedisBroadcaster(addr, channel string) *redisBroadcaster {
+	return &redisBroadcaster{
+		client:  redis.NewClient(&redis.Options{Addr: addr}),
+		channel: channel,
+		ctx:     context.Background(),
+	}
+}
+
+func (b *redisBroadcaster) Publish(msg []byte) error {
+	return b.client.Publish(b.ctx, b.channel, msg).Err()
+}
+
+func (b *redisBroadcaster) Subscribe(out chan<- []byte) {
+	sub := b.client.Subscribe(b.ctx, b.channel)
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		out <- []byte(msg.Payload)
+	}
+}
+
+// newBroadcaster picks a Redis-backed broadcaster when cfg.RedisAddr is set,
+// falling back to an in-memory one otherwise.
+func newBroadcaster(cfg Config) Broadcaster {
+	if cfg.RedisAddr == "" {
+		logger.Info("no Redis address configured, broadcasting in-memory only")
+		return newMemoryBroadcaster()
+	}
+
+	logger.Info("broadcasting via Redis", "addr", cfg.RedisAddr, "channel", cfg.RedisChannel)
+	return newRedisBroadcaster(cfg.RedisAddr, cfg.RedisChannel)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Message is a persisted chat line; it's the same shape a client receives
+// live, so a MessageStore can just hand replayed history straight back out.
+type Message = ChatMessage
+
+// MessageStore persists chat history per room so it can be replayed to
+// clients that join a room after messages were already sent.
+type MessageStore interface {
+	Append(room string, msg Message) error
+	Recent(room string, n int) ([]Message, error)
+}
+
+// memoryMessageStore keeps the last N messages per room in a ring buffer;
+// history is lost on restart.
+type memoryMessageStore struct {
+	mu       sync.Mutex
+	capacity int
+	rooms    map[string][]Message
+}
+
+func newMemoryMessageStore(capacity int) *memoryMessageStore {
+	return &memoryMessageStore{capacity: capacity, rooms: make(map[string][]Message)}
+}
+
+func (s *memoryMessageStore) Append(room string, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf := append(s.rooms[room], msg)
+	if len(buf) > s.capacity {
+		buf = buf[len(buf)-s.capacity:]
+	}
+	s.rooms[room] = buf
+	return nil
+}
+
+func (s *memoryMessageStore) Recent(room string, n int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf := s.rooms[room]
+	if n > len(buf) {
+		n = len(buf)
+	}
+	recent := make([]Message, n)
+	copy(recent, buf[len(buf)-n:])
+	return recent, nil
+}
+
+// sqliteMessageStore persists chat history to a SQLite database so it
+// survives server restarts.
+type sqliteMessageStore struct {
+	db *sql.DB
+}
+
+func newSQLiteMessageStore(path string) (*sqliteMessageStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		room TEXT NOT NULL,
+		nickname TEXT NOT NULL,
+		body TEXT NOT NULL,
+		timestamp DATETIME NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteMessageStore{db: db}, nil
+}
+
+func (s *sqliteMessageStore) Append(room string, msg Message) error {
+	_, err := s.db.Exec(
+		`INSERT INTO messages (room, nickname, body, timestamp) VALUES (?, ?, ?, ?)`,
+		room, msg.Nickname, msg.Body, msg.Timestamp,
+	)
+	return err
+}
+
+func (s *sqliteMessageStore) Recent(room string, n int) ([]Message, error) {
+	rows, err := s.db.Query(
+		`SELECT nickname, body, timestamp FROM messages WHERE room = ? ORDER BY id DESC LIMIT ?`,
+		room, n,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []Message
+	for rows.Next() {
+		msg := Message{Room: room}
+		if err := rows.Scan(&msg.Nickname, &msg.Body, &msg.Timestamp); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The query returns newest-first; replay should read oldest-first.
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+// newMessageStore builds the MessageStore configured by cfg.History,
+// defaulting to an in-memory ring buffer.
+func newMessageStore(cfg Config) (MessageStore, error) {
+	switch cfg.History.backend() {
+	case "sqlite":
+		return newSQLiteMessageStore(cfg.History.path())
+	default:
+		return newMemoryMessageStore(defaultHistoryCapacity), nil
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// logger is the package-level logger every log call in this program goes
+// through; it starts with sane defaults so early startup logging works even
+// before Config has been loaded, and is reconfigured once it has.
+var logger = log.NewWithOptions(os.Stderr, log.Options{
+	ReportTimestamp: true,
+	Prefix:          "🖨 GoWebChat",
+})
+
+// configureLogger applies cfg.LogLevel (falling back to the
+// GOWEBCHAT_ENV=prod convention) to the package-level logger.
+func configureLogger(cfg Config) {
+	logger.SetLevel(resolveLogLevel(cfg))
+}
+
+func resolveLogLevel(cfg Config) log.Level {
+	level := strings.ToLower(cfg.LogLevel)
+	if level == "" {
+		if os.Getenv("GOWEBCHAT_ENV") == "prod" {
+			return log.WarnLevel
+		}
+		return log.InfoLevel
+	}
+
+	switch level {
+	case "debug":
+		return log.DebugLevel
+	case "warn", "warning":
+		return log.WarnLevel
+	case "error":
+		return log.ErrorLevel
+	default:
+		return log.InfoLevel
+	}
+}
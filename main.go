@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/charmbracelet/log"
 	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -18,11 +21,146 @@ var (
 	mu             sync.RWMutex
 )
 
+const (
+	// defaultWriteWait is how long a write (including a ping) may take before
+	// the connection is considered dead, used when Config doesn't override it.
+	defaultWriteWait = 10 * time.Second
+	// defaultPongWait is how long we wait for a pong before giving up on a peer.
+	defaultPongWait = 60 * time.Second
+	// defaultMaxMessageSize caps inbound frame size to guard against abusive clients.
+	defaultMaxMessageSize = 512 * 1024
+	// defaultAuthFile is where the default AuthProvider looks for credentials
+	// when Config.AuthFile isn't set.
+	defaultAuthFile = "users.bcrypt"
+	// defaultHistoryPath is where the sqlite MessageStore persists history
+	// when Config.History.Path isn't set.
+	defaultHistoryPath = "history.db"
+	// defaultHistoryCapacity bounds the memory MessageStore's per-room ring
+	// buffer; it's kept well above defaultReplayCount so rooms retain some
+	// scrollback beyond what's replayed on join.
+	defaultHistoryCapacity = 500
+	// defaultReplayCount is how many recent messages a joining client is
+	// sent when Config.History.ReplayCount isn't set.
+	defaultReplayCount = 50
+	// defaultSendBufferSize buffers Client.send so a client that's merely
+	// pacing normally (not actually slow) doesn't trip Room.publish's
+	// backpressure eviction just because write() wasn't parked on the
+	// receive at that exact instant, and so write()'s coalescing loop has
+	// something to actually coalesce.
+	defaultSendBufferSize = 256
+)
+
 type Config struct {
 	AllowedOrigins []string `json:"allowed_origins"`
 	Port           int      `json:"port"`
-	RateLimit      int      `json:"rate_limit"`
-	Timeout        int      `json:"timeout"`
+	// RateLimit is the sustained number of messages per second a client's
+	// token bucket refills at. See rateBurst for the burst size.
+	RateLimit int `json:"rate_limit"`
+
+	// RateBurst is the token bucket burst size for per-client message rate
+	// limiting. Defaults to defaultRateBurst.
+	RateBurst int `json:"rate_burst"`
+	// ConnRateLimit caps new connections per IP per second, enforced before
+	// the WebSocket upgrade. Defaults to defaultConnRateLimit.
+	ConnRateLimit float64 `json:"conn_rate_limit"`
+	// ConnRateBurst is the per-IP connection burst allowed. Defaults to
+	// defaultConnRateBurst.
+	ConnRateBurst int `json:"conn_rate_burst"`
+
+	// WriteWait is the max duration, in seconds, allowed to write a message
+	// (including pings) to the peer. Defaults to defaultWriteWait.
+	WriteWait int `json:"write_wait"`
+	// PongWait is the max duration, in seconds, to wait for a pong before the
+	// connection is considered dead. The ping period is derived from it.
+	// Defaults to defaultPongWait.
+	PongWait int `json:"pong_wait"`
+	// MaxMessageSize caps inbound message size in bytes. Defaults to
+	// defaultMaxMessageSize.
+	MaxMessageSize int64 `json:"max_message_size"`
+
+	// RedisAddr is the address of a Redis server used to fan broadcasts out
+	// across multiple GoWebChat instances behind a load balancer. When
+	// empty, broadcasts stay local to this process.
+	RedisAddr string `json:"redis_addr"`
+	// RedisChannel is the pub/sub channel messages are published to and
+	// subscribed from. Ignored when RedisAddr is empty.
+	RedisChannel string `json:"redis_channel"`
+
+	// LogLevel is one of "debug", "info", "warn", "error". Defaults to
+	// "info", or "warn" when GOWEBCHAT_ENV=prod and LogLevel is unset.
+	LogLevel string `json:"log_level"`
+
+	// AuthFile is the path to the bcrypt credentials file used by the
+	// default AuthProvider. Defaults to defaultAuthFile.
+	AuthFile string `json:"auth_file"`
+
+	// History configures how chat messages are persisted and replayed to
+	// clients that join a room after messages were already sent.
+	History HistoryConfig `json:"history"`
+}
+
+// HistoryConfig configures the MessageStore.
+type HistoryConfig struct {
+	// Backend is "memory" or "sqlite". Defaults to "memory".
+	Backend string `json:"backend"`
+	// Path is the SQLite database file. Ignored when Backend is "memory".
+	Path string `json:"path"`
+	// ReplayCount is how many recent messages a client is sent after
+	// joining a room. Defaults to defaultReplayCount.
+	ReplayCount int `json:"replay_count"`
+}
+
+func (h HistoryConfig) backend() string {
+	if h.Backend == "" {
+		return "memory"
+	}
+	return h.Backend
+}
+
+func (h HistoryConfig) path() string {
+	if h.Path == "" {
+		return defaultHistoryPath
+	}
+	return h.Path
+}
+
+func (h HistoryConfig) replayCount() int {
+	if h.ReplayCount <= 0 {
+		return defaultReplayCount
+	}
+	return h.ReplayCount
+}
+
+func (c Config) writeWait() time.Duration {
+	if c.WriteWait <= 0 {
+		return defaultWriteWait
+	}
+	return time.Duration(c.WriteWait) * time.Second
+}
+
+func (c Config) pongWait() time.Duration {
+	if c.PongWait <= 0 {
+		return defaultPongWait
+	}
+	return time.Duration(c.PongWait) * time.Second
+}
+
+func (c Config) pingPeriod() time.Duration {
+	return (c.pongWait() * 9) / 10
+}
+
+func (c Config) maxMessageSize() int64 {
+	if c.MaxMessageSize <= 0 {
+		return defaultMaxMessageSize
+	}
+	return c.MaxMessageSize
+}
+
+func (c Config) authFile() string {
+	if c.AuthFile == "" {
+		return defaultAuthFile
+	}
+	return c.AuthFile
 }
 
 func loadConfig(filename string) (Config, error) {
@@ -45,117 +183,355 @@ func checkOrigin(r *http.Request) bool {
 		return true
 	}
 
-	log.Printf("Connection from disallowed origin: %s", origin)
+	logger.Warn("connection from disallowed origin", "origin", origin)
 	return false
 }
 
 type Client struct {
-	conn         *websocket.Conn
-	send         chan []byte
-	lastActive   time.Time
-	messageCount int
-	rateLimit    int
+	conn       *websocket.Conn
+	send       chan []byte
+	lastActive time.Time
+
+	// closeCh and closeOnce let Room.publish (backpressure eviction) and
+	// Client.read (normal teardown) both tear a client down without racing:
+	// neither ever closes send directly, since respond/replayHistory write
+	// to it from the read goroutine while Room.publish writes to it from
+	// the goroutine fanning out broadcasts. See disconnect and enqueue.
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	// limiter paces inbound messages; rateViolations counts consecutive
+	// messages dropped for exceeding it, closing the connection once it
+	// reaches maxConsecutiveRateViolations.
+	limiter        *rate.Limiter
+	rateViolations int
+
+	writeWait      time.Duration
+	pongWait       time.Duration
+	pingPeriod     time.Duration
+	maxMessageSize int64
+
+	// log is a child of the package logger carrying this client's remote
+	// address and origin so its events can be traced without repeating them.
+	log *log.Logger
+
+	// Nickname is set once AuthRequest succeeds; until then the client may
+	// only send a KindAuth frame.
+	Nickname string
+	// Room is the name of the room this client has joined, if any.
+	Room string
+	room *Room
 }
 
-type Hub struct {
-	clients   map[*Client]bool
-	broadcast chan []byte
-	mu        sync.Mutex
+var broadcaster Broadcaster = newMemoryBroadcaster()
+var rooms = newRoomManager()
+var authProvider AuthProvider
+var messageStore MessageStore = newMemoryMessageStore(defaultHistoryCapacity)
+var historyReplayCount = defaultReplayCount
+// connLimiters is an atomic.Pointer so reloadConfig can swap in a limiter
+// built from new conn_rate_limit/conn_rate_burst settings without a lock.
+var connLimiters atomic.Pointer[connLimiter]
+
+// disconnect signals write() to stop and close the connection; it's safe to
+// call more than once and from more than one goroutine.
+func (c *Client) disconnect() {
+	c.closeOnce.Do(func() { close(c.closeCh) })
 }
 
-var hub = Hub{
-	clients:   make(map[*Client]bool),
-	broadcast: make(chan []byte),
+// enqueue queues frame on c.send, but gives up instead of blocking forever
+// if the client is already disconnecting and nothing is left to drain it.
+func (c *Client) enqueue(frame []byte) {
+	select {
+	case c.send <- frame:
+	case <-c.closeCh:
+	}
 }
 
-func (h *Hub) run() {
-	for {
-		msg := <-h.broadcast
-		h.mu.Lock()
-		for client := range h.clients {
-			select {
-			case client.send <- msg:
-			default:
-				close(client.send)
-				delete(h.clients, client)
-			}
+// respond marshals a ResponseFrame and queues it on c.send, mirroring a
+// frame's request ID so the caller can line the answer up with its request.
+func (c *Client) respond(id string, kind FrameKind, ok bool, errMsg string, payload interface{}) {
+	frame, err := json.Marshal(ResponseFrame{ID: id, Kind: kind, OK: ok, Error: errMsg, Payload: payload})
+	if err != nil {
+		c.log.Error("failed to marshal response frame", "err", err)
+		return
+	}
+	c.enqueue(frame)
+}
+
+// replayHistory sends history to this client alone, so it sees scrollback
+// without re-broadcasting to the room. history comes from
+// Room.joinWithHistory, which reads it atomically with this client joining
+// the room, so nothing published after is replayed here and everything
+// published after is delivered live instead.
+func (c *Client) replayHistory(history []Message) {
+	for _, msg := range history {
+		frame, err := json.Marshal(ResponseFrame{Kind: KindMsg, OK: true, Payload: msg})
+		if err != nil {
+			c.log.Error("failed to marshal history message", "err", err)
+			continue
 		}
-		h.mu.Unlock()
+		c.enqueue(frame)
 	}
 }
 
-func (c *Client) read(timeout time.Duration) {
+func (c *Client) leaveRoom() {
+	if c.room == nil {
+		return
+	}
+	c.room.leave(c)
+	c.room = nil
+	c.Room = ""
+}
+
+func (c *Client) handleFrame(frame RequestFrame) {
+	if c.Nickname == "" && frame.Kind != KindAuth {
+		c.respond(frame.ID, frame.Kind, false, "not authenticated", nil)
+		return
+	}
+
+	switch frame.Kind {
+	case KindAuth:
+		if c.Nickname != "" {
+			c.respond(frame.ID, frame.Kind, false, "already authenticated", nil)
+			return
+		}
+		var req AuthRequest
+		if err := json.Unmarshal(frame.Payload, &req); err != nil {
+			c.respond(frame.ID, frame.Kind, false, "malformed auth payload", nil)
+			return
+		}
+		if err := authProvider.Authenticate(req.Nickname, req.Password); err != nil {
+			c.respond(frame.ID, frame.Kind, false, "invalid nickname or password", nil)
+			return
+		}
+		c.Nickname = req.Nickname
+		c.log = c.log.With("nickname", c.Nickname)
+		c.respond(frame.ID, frame.Kind, true, "", nil)
+
+	case KindJoin:
+		var req JoinRequest
+		if err := json.Unmarshal(frame.Payload, &req); err != nil {
+			c.respond(frame.ID, frame.Kind, false, "malformed join payload", nil)
+			return
+		}
+		c.leaveRoom()
+		c.room = rooms.get(req.Room)
+		c.Room = req.Room
+
+		history, err := c.room.joinWithHistory(c, historyReplayCount)
+		if err != nil {
+			c.log.Error("failed to load room history", "err", err)
+		}
+		c.respond(frame.ID, frame.Kind, true, "", nil)
+		c.replayHistory(history)
+
+	case KindLeave:
+		c.leaveRoom()
+		c.respond(frame.ID, frame.Kind, true, "", nil)
+
+	case KindMsg:
+		if c.room == nil {
+			c.respond(frame.ID, frame.Kind, false, "not in a room", nil)
+			return
+		}
+		var req MsgRequest
+		if err := json.Unmarshal(frame.Payload, &req); err != nil {
+			c.respond(frame.ID, frame.Kind, false, "malformed msg payload", nil)
+			return
+		}
+		raw, err := json.Marshal(ChatMessage{Room: c.Room, Nickname: c.Nickname, Body: req.Body, Timestamp: time.Now()})
+		if err != nil {
+			c.log.Error("failed to marshal chat message", "err", err)
+			return
+		}
+		if err := broadcaster.Publish(raw); err != nil {
+			c.log.Error("failed to publish message", "err", err)
+			return
+		}
+
+	case KindList:
+		if c.room == nil {
+			c.respond(frame.ID, frame.Kind, false, "not in a room", nil)
+			return
+		}
+		c.respond(frame.ID, frame.Kind, true, "", ListResponse{Members: c.room.members()})
+
+	default:
+		c.respond(frame.ID, frame.Kind, false, "unknown frame kind", nil)
+	}
+}
+
+func (c *Client) read() {
 	defer func() {
-		c.conn.Close()
+		c.leaveRoom()
+		c.disconnect()
 	}()
+
+	c.conn.SetReadLimit(c.maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
+		return nil
+	})
+
 	for {
-		c.conn.SetReadDeadline(time.Now().Add(timeout))
 		_, msg, err := c.conn.ReadMessage()
 		if err != nil {
 			break
 		}
 
-		c.messageCount++
-		if c.messageCount > c.rateLimit {
-			log.Println("Rate limit exceeded for client, closing connection.")
-			break
+		if r := c.limiter.Reserve(); r.Delay() > 0 {
+			r.Cancel()
+			c.rateViolations++
+			c.log.Warn("rate limit exceeded, dropping message", "consecutive_violations", c.rateViolations)
+			c.respond("", KindRateLimited, false, "rate limited", RateLimitedResponse{
+				RetryAfterMs: r.Delay().Milliseconds(),
+			})
+			if c.rateViolations >= maxConsecutiveRateViolations {
+				c.log.Warn("too many consecutive rate limit violations, closing connection")
+				break
+			}
+			continue
 		}
+		c.rateViolations = 0
 
-		hub.broadcast <- msg
+		var frame RequestFrame
+		if err := json.Unmarshal(msg, &frame); err != nil {
+			c.respond("", "", false, "malformed request frame", nil)
+			continue
+		}
+		c.handleFrame(frame)
 		c.lastActive = time.Now()
 	}
 }
 
 func (c *Client) write() {
+	ticker := time.NewTicker(c.pingPeriod)
 	defer func() {
+		ticker.Stop()
 		c.conn.Close()
 	}()
-	for msg := range c.send {
-		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-			break
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.writeWait))
+
+			w, err := c.conn.NextWriter(websocket.TextMessage)
+			if err != nil {
+				return
+			}
+			w.Write(msg)
+
+			// Coalesce any messages queued up while we were writing into
+			// the same frame instead of issuing one WriteMessage per send.
+			n := len(c.send)
+			for i := 0; i < n; i++ {
+				w.Write(<-c.send)
+			}
+
+			if err := w.Close(); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+
+		case <-c.closeCh:
+			c.conn.SetWriteDeadline(time.Now().Add(c.writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
 		}
 	}
 }
 
-func handleConnection(w http.ResponseWriter, r *http.Request, rateLimit int, timeout time.Duration) {
+func handleConnection(w http.ResponseWriter, r *http.Request, cfg Config) {
+	if !connLimiters.Load().allow(clientIP(r)) {
+		logger.Warn("connection rate limit exceeded", "remote_addr", r.RemoteAddr)
+		http.Error(w, "too many connection attempts", http.StatusTooManyRequests)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Error during connection upgrade:", err)
+		logger.Error("error during connection upgrade", "err", err)
 		return
 	}
-	client := &Client{conn: conn, send: make(chan []byte), lastActive: time.Now(), rateLimit: rateLimit}
-	hub.mu.Lock()
-	hub.clients[client] = true
-	hub.mu.Unlock()
+	client := &Client{
+		conn:           conn,
+		send:           make(chan []byte, defaultSendBufferSize),
+		closeCh:        make(chan struct{}),
+		lastActive:     time.Now(),
+		limiter:        rate.NewLimiter(rate.Limit(cfg.RateLimit), cfg.rateBurst()),
+		writeWait:      cfg.writeWait(),
+		pongWait:       cfg.pongWait(),
+		pingPeriod:     cfg.pingPeriod(),
+		maxMessageSize: cfg.maxMessageSize(),
+		log:            logger.With("remote_addr", r.RemoteAddr, "origin", r.Header.Get("Origin")),
+	}
 
-	go client.read(timeout)
+	go client.read()
 	go client.write()
 }
 
-var config Config
+const configPath = "config.json"
+
+// currentConfig holds the live Config; new connections read it via Load so
+// a hot reload takes effect without dropping connections already in flight.
+var currentConfig atomic.Pointer[Config]
 
 func main() {
-	var err error
-	config, err = loadConfig("config.json")
+	cfg, err := loadConfig(configPath)
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		logger.Fatal("error loading config", "err", err)
 	}
+	currentConfig.Store(&cfg)
+	configureLogger(cfg)
 
 	mu.Lock()
-	for _, origin := range config.AllowedOrigins {
+	for _, origin := range cfg.AllowedOrigins {
 		allowedOrigins[origin] = struct{}{}
 	}
 	mu.Unlock()
 
-	go hub.run()
+	authProvider = newBcryptFileAuthProvider(cfg.authFile())
+	connLimiters.Store(newConnLimiter(cfg.connRateLimit(), cfg.connRateBurst()))
+
+	store, err := newMessageStore(cfg)
+	if err != nil {
+		logger.Fatal("failed to initialize message store", "err", err)
+	}
+	messageStore = store
+	historyReplayCount = cfg.History.replayCount()
+
+	broadcaster = newBroadcaster(cfg)
+	incoming := make(chan []byte)
+	go broadcaster.Subscribe(incoming)
+	go func() {
+		for raw := range incoming {
+			var msg ChatMessage
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				logger.Error("failed to decode broadcast message", "err", err)
+				continue
+			}
+			if err := rooms.get(msg.Room).publish(msg); err != nil {
+				logger.Error("failed to persist and publish message", "err", err)
+			}
+		}
+	}()
+
+	go watchConfig(context.Background(), configPath)
 
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		handleConnection(w, r, config.RateLimit, time.Duration(config.Timeout)*time.Second)
+		handleConnection(w, r, *currentConfig.Load())
 	})
 
-	addr := fmt.Sprintf(":%d", config.Port)
-	log.Printf("Запуск сервера на порту %d...", config.Port)
+	addr := fmt.Sprintf(":%d", cfg.Port)
+	logger.Info("starting server", "port", cfg.Port)
 	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Fatalf("Ошибка при запуске сервера: %v", err)
+		logger.Fatal("server failed to start", "err", err)
 	}
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FrameKind identifies the payload carried by a RequestFrame/ResponseFrame
+// so the protocol can grow new kinds without breaking existing ones.
+type FrameKind string
+
+const (
+	KindAuth        FrameKind = "auth"
+	KindJoin        FrameKind = "join"
+	KindLeave       FrameKind = "leave"
+	KindMsg         FrameKind = "msg"
+	KindList        FrameKind = "list"
+	KindRateLimited FrameKind = "rate_limited"
+)
+
+// RequestFrame is the envelope every inbound client message arrives in.
+// Payload is decoded into the type matching Kind once the handler knows it.
+type RequestFrame struct {
+	ID      string          `json:"id"`
+	Kind    FrameKind       `json:"kind"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// ResponseFrame is the envelope every outbound message leaves in. ID
+// echoes the RequestFrame it answers, or is empty for server-initiated
+// pushes such as a relayed chat message.
+type ResponseFrame struct {
+	ID      string      `json:"id,omitempty"`
+	Kind    FrameKind   `json:"kind"`
+	OK      bool        `json:"ok"`
+	Error   string      `json:"error,omitempty"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// AuthRequest is the Payload of a KindAuth RequestFrame.
+type AuthRequest struct {
+	Nickname string `json:"nickname"`
+	Password string `json:"password"`
+}
+
+// JoinRequest is the Payload of a KindJoin RequestFrame.
+type JoinRequest struct {
+	Room string `json:"room"`
+}
+
+// MsgRequest is the Payload of a KindMsg RequestFrame.
+type MsgRequest struct {
+	Body string `json:"body"`
+}
+
+// ChatMessage is what gets relayed to a room, both over the Broadcaster
+// and, wrapped in a ResponseFrame, down to each member's send channel. It
+// also doubles as the record persisted by a MessageStore for replay.
+type ChatMessage struct {
+	Room      string    `json:"room"`
+	Nickname  string    `json:"nickname"`
+	Body      string    `json:"body"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ListResponse is the Payload of the ResponseFrame answering a KindList request.
+type ListResponse struct {
+	Members []string `json:"members"`
+}
+
+// RateLimitedResponse is the Payload of an unsolicited KindRateLimited
+// ResponseFrame sent when a client exceeds its per-connection message rate.
+type RateLimitedResponse struct {
+	RetryAfterMs int64 `json:"retry_after_ms"`
+}
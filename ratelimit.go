@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultRateBurst is the per-client token bucket burst used when
+	// Config.RateBurst isn't set.
+	defaultRateBurst = 5
+	// maxConsecutiveRateViolations is how many consecutive rate-limited
+	// messages a client can send before the connection is closed outright.
+	maxConsecutiveRateViolations = 5
+	// defaultConnRateLimit caps new connections per IP per second when
+	// Config.ConnRateLimit isn't set.
+	defaultConnRateLimit = 1
+	// defaultConnRateBurst is the per-IP connection burst allowed when
+	// Config.ConnRateBurst isn't set.
+	defaultConnRateBurst = 5
+	// connLimiterIdleTTL is how long an IP can go without a connection
+	// attempt before its bucket is swept, so connLimiter doesn't grow
+	// forever as distinct addresses come and go.
+	connLimiterIdleTTL = 10 * time.Minute
+	// connLimiterSweepInterval is how often connLimiter checks for idle
+	// entries to evict.
+	connLimiterSweepInterval = time.Minute
+)
+
+func (c Config) rateBurst() int {
+	if c.RateBurst <= 0 {
+		return defaultRateBurst
+	}
+	return c.RateBurst
+}
+
+func (c Config) connRateLimit() rate.Limit {
+	if c.ConnRateLimit <= 0 {
+		return rate.Limit(defaultConnRateLimit)
+	}
+	return rate.Limit(c.ConnRateLimit)
+}
+
+func (c Config) connRateBurst() int {
+	if c.ConnRateBurst <= 0 {
+		return defaultConnRateBurst
+	}
+	return c.ConnRateBurst
+}
+
+// connLimiter enforces a per-IP connection rate limit at the front door, so
+// a flood of upgrade attempts from one address can't exhaust server
+// resources before a Client is ever created. Entries for addresses that
+// haven't connected in a while are swept so the map doesn't grow forever.
+type connLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*limiterEntry
+	limit    rate.Limit
+	burst    int
+	stop     chan struct{}
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newConnLimiter(limit rate.Limit, burst int) *connLimiter {
+	c := &connLimiter{limiters: make(map[string]*limiterEntry), limit: limit, burst: burst, stop: make(chan struct{})}
+	go c.sweepLoop()
+	return c
+}
+
+// close stops the limiter's sweep goroutine. Call it on the old limiter
+// after reloadConfig swaps in a replacement, so reloading doesn't leak a
+// sweepLoop per reload.
+func (c *connLimiter) close() {
+	close(c.stop)
+}
+
+func (c *connLimiter) allow(ip string) bool {
+	c.mu.Lock()
+	entry, ok := c.limiters[ip]
+	if !ok {
+		entry = &limiterEntry{limiter: rate.NewLimiter(c.limit, c.burst)}
+		c.limiters[ip] = entry
+	}
+	entry.lastSeen = time.Now()
+	c.mu.Unlock()
+
+	return entry.limiter.Allow()
+}
+
+// sweepLoop evicts IPs that haven't attempted a connection in
+// connLimiterIdleTTL, freeing the bucket it was holding. It runs until
+// close is called.
+func (c *connLimiter) sweepLoop() {
+	ticker := time.NewTicker(connLimiterSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cutoff := time.Now().Add(-connLimiterIdleTTL)
+			c.mu.Lock()
+			for ip, entry := range c.limiters {
+				if entry.lastSeen.Before(cutoff) {
+					delete(c.limiters, ip)
+				}
+			}
+			c.mu.Unlock()
+
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// clientIP strips the port off r.RemoteAddr so every connection from the
+// same address shares one bucket regardless of its ephemeral source port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
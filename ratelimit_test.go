@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestConnLimiterAllowsBurstThenThrottles(t *testing.T) {
+	cl := newConnLimiter(rate.Limit(1), 2)
+	defer cl.close()
+
+	ip := "203.0.113.1"
+	if !cl.allow(ip) {
+		t.Fatal("expected the first connection to be allowed")
+	}
+	if !cl.allow(ip) {
+		t.Fatal("expected the burst's second connection to be allowed")
+	}
+	if cl.allow(ip) {
+		t.Fatal("expected a third connection within the burst window to be rate limited")
+	}
+}
+
+func TestConnLimiterTracksIPsIndependently(t *testing.T) {
+	cl := newConnLimiter(rate.Limit(1), 1)
+	defer cl.close()
+
+	if !cl.allow("203.0.113.1") {
+		t.Fatal("expected the first IP's first connection to be allowed")
+	}
+	if cl.allow("203.0.113.1") {
+		t.Fatal("expected the first IP's second connection to be rate limited")
+	}
+	if !cl.allow("203.0.113.2") {
+		t.Fatal("expected a different IP's first connection to be unaffected by the first IP's bucket")
+	}
+}
+
+func TestConnLimiterAllowAfterCloseDoesNotPanic(t *testing.T) {
+	cl := newConnLimiter(rate.Limit(1), 1)
+	cl.allow("203.0.113.1")
+	cl.close()
+
+	// close should be safe to call once and should not panic a subsequent
+	// allow (sweepLoop exiting doesn't tear down the limiter map itself).
+	cl.allow("203.0.113.1")
+}
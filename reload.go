@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchConfig re-reads configPath whenever it changes on disk, or the
+// process receives SIGHUP, and swaps it into currentConfig. Connections
+// already being served keep running under their original settings; only
+// new connections pick up the reloaded allowed-origins, rate limit, and
+// conn rate limit.
+//
+// It watches configPath's parent directory rather than the file itself:
+// most config deploys (editors, `mv tmp config.json`, a mounted
+// ConfigMap update) replace the file atomically, which fires a
+// Remove/Rename event instead of Write and, on Linux, invalidates an
+// inotify watch held on the old inode — a watch on the file would go
+// silently dead after the first such update. Watching the directory and
+// filtering by name survives every replacement.
+func watchConfig(ctx context.Context, configPath string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error("failed to start config watcher", "err", err)
+		return
+	}
+	defer watcher.Close()
+
+	dir := filepath.Dir(configPath)
+	if err := watcher.Add(dir); err != nil {
+		logger.Error("failed to watch config directory", "dir", dir, "err", err)
+		return
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(configPath) {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+				reloadConfig(configPath)
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Error("config watcher error", "err", err)
+
+		case <-sighup:
+			logger.Info("received SIGHUP, reloading config")
+			reloadConfig(configPath)
+		}
+	}
+}
+
+func reloadConfig(configPath string) {
+	next, err := loadConfig(configPath)
+	if err != nil {
+		logger.Error("failed to reload config", "path", configPath, "err", err)
+		return
+	}
+
+	prev := currentConfig.Load()
+	logConfigDiff(*prev, next)
+
+	mu.Lock()
+	allowedOrigins = make(map[string]struct{}, len(next.AllowedOrigins))
+	for _, origin := range next.AllowedOrigins {
+		allowedOrigins[origin] = struct{}{}
+	}
+	mu.Unlock()
+
+	if prev.connRateLimit() != next.connRateLimit() || prev.connRateBurst() != next.connRateBurst() {
+		old := connLimiters.Load()
+		connLimiters.Store(newConnLimiter(next.connRateLimit(), next.connRateBurst()))
+		if old != nil {
+			old.close()
+		}
+	}
+
+	currentConfig.Store(&next)
+}
+
+// logConfigDiff logs only the fields that actually changed between reloads,
+// so ops can see what a reload did without diffing the file themselves.
+func logConfigDiff(prev, next Config) {
+	if !reflect.DeepEqual(prev.AllowedOrigins, next.AllowedOrigins) {
+		logger.Info("config reload: allowed_origins changed", "from", prev.AllowedOrigins, "to", next.AllowedOrigins)
+	}
+	if prev.RateLimit != next.RateLimit {
+		logger.Info("config reload: rate_limit changed", "from", prev.RateLimit, "to", next.RateLimit)
+	}
+	if prev.connRateLimit() != next.connRateLimit() || prev.connRateBurst() != next.connRateBurst() {
+		logger.Info("config reload: conn rate limit changed",
+			"from_limit", prev.connRateLimit(), "to_limit", next.connRateLimit(),
+			"from_burst", prev.connRateBurst(), "to_burst", next.connRateBurst())
+	}
+}
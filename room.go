@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Room fans broadcasts out to the clients that have joined it; it replaces
+// the single global Hub now that clients pick a room after authenticating.
+type Room struct {
+	name    string
+	clients map[*Client]bool
+	mu      sync.Mutex
+}
+
+func newRoom(name string) *Room {
+	return &Room{
+		name:    name,
+		clients: make(map[*Client]bool),
+	}
+}
+
+// publish persists msg to the room's history and fans it out to every
+// member, atomically with respect to joinWithHistory: a message is either
+// already in the history joinWithHistory reads, or the joining client is
+// already a member and receives it here live — never both, never neither.
+func (r *Room) publish(msg ChatMessage) error {
+	frame, err := json.Marshal(ResponseFrame{Kind: KindMsg, OK: true, Payload: msg})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := messageStore.Append(r.name, msg); err != nil {
+		return err
+	}
+
+	for client := range r.clients {
+		select {
+		case client.send <- frame:
+		default:
+			// Client isn't draining send fast enough; evict it. We
+			// never close client.send here — Client.read also writes
+			// to it via respond/replayHistory, so only disconnect (via
+			// closeCh) may tear the client down.
+			delete(r.clients, client)
+			client.disconnect()
+		}
+	}
+	return nil
+}
+
+// joinWithHistory adds c to the room and returns the last n messages to
+// replay, under the same lock publish uses, so no message can land in the
+// gap between reading history and becoming a member.
+func (r *Room) joinWithHistory(c *Client, n int) ([]Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history, err := messageStore.Recent(r.name, n)
+	if err != nil {
+		return nil, err
+	}
+	r.clients[c] = true
+	return history, nil
+}
+
+func (r *Room) leave(c *Client) {
+	r.mu.Lock()
+	delete(r.clients, c)
+	r.mu.Unlock()
+}
+
+func (r *Room) members() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.clients))
+	for c := range r.clients {
+		names = append(names, c.Nickname)
+	}
+	return names
+}
+
+// RoomManager owns every Room, creating one lazily the first time a client
+// joins it so room names don't need to be configured ahead of time.
+type RoomManager struct {
+	mu    sync.Mutex
+	rooms map[string]*Room
+}
+
+func newRoomManager() *RoomManager {
+	return &RoomManager{rooms: make(map[string]*Room)}
+}
+
+func (m *RoomManager) get(name string) *Room {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.rooms[name]
+	if !ok {
+		room = newRoom(name)
+		m.rooms[name] = room
+	}
+	return room
+}
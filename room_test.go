@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestClient() *Client {
+	return &Client{send: make(chan []byte, defaultSendBufferSize), closeCh: make(chan struct{})}
+}
+
+func TestRoomJoinWithHistoryIncludesMessagesPublishedBeforeJoin(t *testing.T) {
+	room := newRoom(t.Name())
+
+	if err := room.publish(ChatMessage{Room: room.name, Nickname: "alice", Body: "before join"}); err != nil {
+		t.Fatalf("publish before join: %v", err)
+	}
+
+	client := newTestClient()
+	history, err := room.joinWithHistory(client, defaultReplayCount)
+	if err != nil {
+		t.Fatalf("joinWithHistory: %v", err)
+	}
+	if len(history) != 1 || history[0].Body != "before join" {
+		t.Fatalf("expected history to contain the pre-join message, got %+v", history)
+	}
+
+	select {
+	case frame := <-client.send:
+		t.Fatalf("pre-join message must not also be delivered live, got %s", frame)
+	default:
+	}
+}
+
+func TestRoomJoinWithHistoryDeliversMessagesPublishedAfterJoinLive(t *testing.T) {
+	room := newRoom(t.Name())
+
+	client := newTestClient()
+	if _, err := room.joinWithHistory(client, defaultReplayCount); err != nil {
+		t.Fatalf("joinWithHistory: %v", err)
+	}
+
+	if err := room.publish(ChatMessage{Room: room.name, Nickname: "alice", Body: "after join"}); err != nil {
+		t.Fatalf("publish after join: %v", err)
+	}
+
+	select {
+	case <-client.send:
+	case <-time.After(time.Second):
+		t.Fatal("expected the post-join message to be delivered live")
+	}
+}
+
+// TestRoomJoinWithHistoryRacingPublish guards against the bug where
+// replaying history before joining the room could drop a message that was
+// published in the gap between the two: it would miss both the history
+// snapshot and the live broadcast. publish and joinWithHistory share a
+// lock, so the racing message must show up exactly once, either in history
+// or live, never in both and never in neither.
+func TestRoomJoinWithHistoryRacingPublish(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		room := newRoom(fmt.Sprintf("%s-%d", t.Name(), i))
+		client := newTestClient()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if err := room.publish(ChatMessage{Room: room.name, Nickname: "bob", Body: "racing message"}); err != nil {
+				t.Errorf("publish: %v", err)
+			}
+		}()
+
+		history, err := room.joinWithHistory(client, defaultReplayCount)
+		if err != nil {
+			t.Fatalf("joinWithHistory: %v", err)
+		}
+		<-done
+
+		seen := len(history)
+		select {
+		case <-client.send:
+			seen++
+		default:
+		}
+
+		if seen != 1 {
+			t.Fatalf("expected the racing message to be seen exactly once, got %d", seen)
+		}
+	}
+}